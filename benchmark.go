@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// codecResult is one encoding's outcome when recompressing a single block.
+type codecResult struct {
+	Encoding        string  `json:"encoding"`
+	Size            int     `json:"size"`
+	Ratio           float64 `json:"ratio"`
+	CompressNanos   int64   `json:"compress_ns"`
+	DecompressNanos int64   `json:"decompress_ns"`
+}
+
+// blockBenchmark is the per-block row of the -benchmark report.
+type blockBenchmark struct {
+	Index              int           `json:"index"`
+	UncompressedLength int           `json:"uncompressed_length"`
+	Results            []codecResult `json:"results"`
+}
+
+// codecSummary aggregates one encoding's codecResults across every block in
+// the chunk.
+type codecSummary struct {
+	Encoding             string  `json:"encoding"`
+	GeometricMeanRatio   float64 `json:"geometric_mean_ratio"`
+	P50CompressNsPerByte float64 `json:"p50_compress_ns_per_byte"`
+	P99CompressNsPerByte float64 `json:"p99_compress_ns_per_byte"`
+}
+
+type chunkBenchmark struct {
+	Filename string           `json:"filename"`
+	Blocks   []blockBenchmark `json:"blocks"`
+	Summary  []codecSummary   `json:"summary"`
+}
+
+// benchmarkChunk decompresses each block once, then recompresses it with
+// every registered encoding, recording compress/decompress wall-clock time
+// and the resulting size. It answers "what encoding should my tenant use?"
+// without spinning up a full ingester.
+func benchmarkChunk(filename string, lokiChunk *LokiChunk) *chunkBenchmark {
+	report := &chunkBenchmark{Filename: filename}
+
+	nsPerByte := map[string][]float64{}
+	ratios := map[string][]float64{}
+
+	for ix, b := range lokiChunk.blocks {
+		raw := entriesToBytesWithMetadata(b.entries)
+		block := blockBenchmark{Index: ix, UncompressedLength: len(raw)}
+
+		for _, enc := range Encodings {
+			if enc.writerFn == nil {
+				// Encodings added through the public RegisterEncoding hook
+				// only carry a readerFn (it's a decode-only registration),
+				// so there's nothing to benchmark compression with here.
+				continue
+			}
+
+			var compressed bytes.Buffer
+			compressStart := time.Now()
+			w, err := enc.writerFn(&compressed)
+			if err == nil {
+				_, err = w.Write(raw)
+			}
+			if err == nil {
+				err = w.Close()
+			}
+			compressNanos := time.Since(compressStart).Nanoseconds()
+			if err != nil {
+				continue
+			}
+
+			decompressStart := time.Now()
+			r, err := enc.readerFn(bytes.NewReader(compressed.Bytes()))
+			if err == nil {
+				_, err = ioutil.ReadAll(r)
+			}
+			decompressNanos := time.Since(decompressStart).Nanoseconds()
+			if err != nil {
+				continue
+			}
+
+			size := compressed.Len()
+			ratio := float64(len(raw)) / float64(size)
+
+			block.Results = append(block.Results, codecResult{
+				Encoding:        enc.name,
+				Size:            size,
+				Ratio:           ratio,
+				CompressNanos:   compressNanos,
+				DecompressNanos: decompressNanos,
+			})
+
+			ratios[enc.name] = append(ratios[enc.name], ratio)
+			if size > 0 {
+				nsPerByte[enc.name] = append(nsPerByte[enc.name], float64(compressNanos)/float64(size))
+			}
+		}
+
+		report.Blocks = append(report.Blocks, block)
+	}
+
+	for _, enc := range Encodings {
+		rs := ratios[enc.name]
+		if len(rs) == 0 {
+			continue
+		}
+		report.Summary = append(report.Summary, codecSummary{
+			Encoding:             enc.name,
+			GeometricMeanRatio:   geometricMean(rs),
+			P50CompressNsPerByte: percentile(nsPerByte[enc.name], 0.50),
+			P99CompressNsPerByte: percentile(nsPerByte[enc.name], 0.99),
+		})
+	}
+
+	return report
+}
+
+// entriesToBytes re-serializes a block's entries into the timestamp/line
+// stream a format < v4 parseLokiBlock decodes, so it can be fed back into a
+// different codec's writer. It intentionally omits StructuredMetadata: it's
+// used by TranscodeChunk, whose output is always written as format 2 (see
+// TranscodeChunk), a layout with no metadata section — embedding it here
+// would shift every following entry's bytes and corrupt the re-parse.
+func entriesToBytes(entries []LokiEntry) []byte {
+	var buf bytes.Buffer
+	var scratch [binary.MaxVarintLen64]byte
+
+	for _, e := range entries {
+		n := binary.PutVarint(scratch[:], e.timestamp)
+		buf.Write(scratch[:n])
+		n = binary.PutUvarint(scratch[:], uint64(len(e.line)))
+		buf.Write(scratch[:n])
+		buf.WriteString(e.line)
+	}
+
+	return buf.Bytes()
+}
+
+// entriesToBytesWithMetadata is entriesToBytes plus each entry's structured
+// metadata, laid out the way readStructuredMetadata expects. -benchmark only
+// ever feeds this through a codec and measures the result; it never writes
+// a chunk format header, so there's no re-parse to corrupt, and including
+// metadata here is what makes a v4 block's reported uncompressed size (and
+// therefore its compression ratio) reflect its real payload.
+func entriesToBytesWithMetadata(entries []LokiEntry) []byte {
+	var buf bytes.Buffer
+	var scratch [binary.MaxVarintLen64]byte
+
+	for _, e := range entries {
+		n := binary.PutVarint(scratch[:], e.timestamp)
+		buf.Write(scratch[:n])
+		n = binary.PutUvarint(scratch[:], uint64(len(e.line)))
+		buf.Write(scratch[:n])
+		buf.WriteString(e.line)
+
+		n = binary.PutUvarint(scratch[:], uint64(len(e.StructuredMetadata)))
+		buf.Write(scratch[:n])
+		for _, l := range e.StructuredMetadata {
+			n = binary.PutUvarint(scratch[:], uint64(len(l.Name)))
+			buf.Write(scratch[:n])
+			buf.WriteString(l.Name)
+
+			n = binary.PutUvarint(scratch[:], uint64(len(l.Value)))
+			buf.Write(scratch[:n])
+			buf.WriteString(l.Value)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func geometricMean(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += math.Log(v)
+	}
+	return math.Exp(sum / float64(len(values)))
+}
+
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	ix := int(p * float64(len(sorted)-1))
+	return sorted[ix]
+}
+
+func printBenchmarkText(report *chunkBenchmark) {
+	fmt.Println()
+	fmt.Println("Benchmark:", report.Filename)
+	for _, b := range report.Blocks {
+		fmt.Printf("Block %4d: uncompressed %d bytes\n", b.Index, b.UncompressedLength)
+		for _, r := range b.Results {
+			fmt.Printf("  %-8s size: %8d ratio: %5.2f compress: %10s decompress: %10s\n",
+				r.Encoding, r.Size, r.Ratio, time.Duration(r.CompressNanos), time.Duration(r.DecompressNanos))
+		}
+	}
+
+	fmt.Println("Summary (geometric mean ratio, compress ns/byte p50/p99):")
+	for _, s := range report.Summary {
+		fmt.Printf("  %-8s ratio: %5.2f p50: %8.2f p99: %8.2f\n", s.Encoding, s.GeometricMeanRatio, s.P50CompressNsPerByte, s.P99CompressNsPerByte)
+	}
+}
+
+func printBenchmarkJSON(report *chunkBenchmark) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// runBenchmark reads filename, benchmarks every block against every known
+// encoding, and prints the report in the requested format so it can be
+// aggregated across thousands of chunks.
+func runBenchmark(filename string, asJSON bool) error {
+	_, lokiChunk, skipped, err := readLokiChunkFile(filename, nil, nil)
+	if err != nil {
+		return err
+	}
+	if skipped {
+		return nil
+	}
+
+	report := benchmarkChunk(filename, lokiChunk)
+
+	if asJSON {
+		return printBenchmarkJSON(report)
+	}
+	printBenchmarkText(report)
+	return nil
+}
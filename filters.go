@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// TimeRange restricts chunk inspection to blocks overlapping [From, To].
+// A nil bound is unbounded on that side. It is checked against each block's
+// minT/maxT before the block is decompressed, so callers only pay to parse
+// the blocks they actually care about.
+type TimeRange struct {
+	From *int64 // unix nanoseconds, inclusive
+	To   *int64 // unix nanoseconds, inclusive
+}
+
+// Overlaps reports whether a block spanning [minT, maxT] overlaps the time
+// range, even partially.
+func (r *TimeRange) Overlaps(minT, maxT int64) bool {
+	if r == nil {
+		return true
+	}
+	if r.From != nil && maxT < *r.From {
+		return false
+	}
+	if r.To != nil && minT > *r.To {
+		return false
+	}
+	return true
+}
+
+// Includes reports whether a single timestamp t falls inside the time range.
+// Overlaps prunes whole blocks by their minT/maxT; Includes does the matching
+// per-entry check so a narrow --from/--to window doesn't let through entries
+// from a block that merely overlaps it.
+func (r *TimeRange) Includes(t int64) bool {
+	if r == nil {
+		return true
+	}
+	if r.From != nil && t < *r.From {
+		return false
+	}
+	if r.To != nil && t > *r.To {
+		return false
+	}
+	return true
+}
+
+// parseTimeFlag parses the --from/--to flag value. It accepts RFC3339 ("2023-
+// 05-01T00:00:00Z") as well as a bare unix timestamp in seconds, so it can be
+// copy-pasted out of Loki/Grafana UIs either way.
+func parseTimeFlag(s string) (int64, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty time value")
+	}
+
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0).UnixNano(), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, want RFC3339 or unix seconds: %w", s, err)
+	}
+	return t.UnixNano(), nil
+}
+
+// LabelMatcher is a parsed --match 'label="value"' flag, checked against a
+// chunk's header labels before the chunk body is even parsed.
+type LabelMatcher struct {
+	Name  string
+	Value string
+}
+
+var matchFlagRe = regexp.MustCompile(`^([a-zA-Z_][a-zA-Z0-9_]*)="(.*)"$`)
+
+func parseLabelMatcher(s string) (*LabelMatcher, error) {
+	m := matchFlagRe.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf(`invalid --match %q, want label="value"`, s)
+	}
+	return &LabelMatcher{Name: m[1], Value: m[2]}, nil
+}
+
+// Matches checks the matcher against a chunk header's labels.
+func (m *LabelMatcher) Matches(h *ChunkHeader) bool {
+	for _, l := range h.Metric {
+		if l.Name == m.Name {
+			return l.Value == m.Value
+		}
+	}
+	return false
+}
+
+// filterEntries returns the entries in es that fall inside timeRange and
+// whose line matches re, preserving order. timeRange only prunes whole
+// blocks by minT/maxT before decompression; this re-checks each entry's own
+// timestamp so a narrow --from/--to window doesn't let through entries from
+// a block that merely overlaps it. A nil timeRange or re passes everything
+// through unfiltered on that axis.
+func filterEntries(es []LokiEntry, timeRange *TimeRange, re *regexp.Regexp) []LokiEntry {
+	if timeRange == nil && re == nil {
+		return es
+	}
+
+	filtered := es[:0:0]
+	for _, e := range es {
+		if !timeRange.Includes(e.timestamp) {
+			continue
+		}
+		if re != nil && !re.MatchString(e.line) {
+			continue
+		}
+		filtered = append(filtered, e)
+	}
+	return filtered
+}
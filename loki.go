@@ -2,28 +2,59 @@ package main
 
 import (
 	"bytes"
+	"compress/flate"
 	"compress/gzip"
 	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
 	"hash/crc32"
 	"io"
-	"io/ioutil"
 
 	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
 	"github.com/pierrec/lz4"
+	"github.com/prometheus/prometheus/model/labels"
 )
 
 type Encoding struct {
 	code     int
 	name     string
 	readerFn func(io.Reader) (io.Reader, error)
+	writerFn func(io.Writer) (io.WriteCloser, error)
 }
 
 func (e Encoding) String() string {
 	return e.name
 }
 
+// UnsupportedCompressionError is returned by getCompression when a chunk was
+// produced with a format or codec this build doesn't know about, e.g. by a
+// newer Loki version. It names the raw header bytes so operators can tell
+// which chunks need a newer chunks-inspect build.
+type UnsupportedCompressionError struct {
+	format byte
+	code   byte
+	known  bool // true if format is recognised but code isn't registered
+}
+
+func (e UnsupportedCompressionError) Error() string {
+	if e.known {
+		return fmt.Sprintf("unknown encoding code %d for chunk format %d", e.code, e.format)
+	}
+	return fmt.Sprintf("unknown chunk format %d", e.format)
+}
+
+// Chunk format versions, stored in the header byte right after the magic
+// number. v1 hard-codes gzip; v2 adds the explicit encoding-code byte; v3 is
+// layout-compatible with v2; v4 additionally carries structured metadata
+// (non-indexed labels) after every entry.
+const (
+	chunkFormatV1 = 1
+	chunkFormatV2 = 2
+	chunkFormatV3 = 3
+	chunkFormatV4 = 4
+)
+
 // The table gets initialized with sync.Once but may still cause a race
 // with any other use of the crc32 package anywhere. Thus we initialize it
 // before.
@@ -33,18 +64,122 @@ func init() {
 	castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
 }
 
+// nopWriteCloser adapts an io.Writer to io.WriteCloser for encodings that
+// don't need to flush or finalize anything, mirroring ioutil.NopCloser for
+// readers.
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
 var (
-	encNone   = Encoding{code: 0, name: "none", readerFn: func(reader io.Reader) (io.Reader, error) { return reader, nil }}
-	encGZIP   = Encoding{code: 1, name: "gzip", readerFn: func(reader io.Reader) (io.Reader, error) { return gzip.NewReader(reader) }}
-	encDumb   = Encoding{code: 2, name: "dumb", readerFn: func(reader io.Reader) (io.Reader, error) { return reader, nil }}
-	encLZ4    = Encoding{code: 3, name: "lz4", readerFn: func(reader io.Reader) (io.Reader, error) { return lz4.NewReader(reader), nil }}
-	encSnappy = Encoding{code: 4, name: "snappy", readerFn: func(reader io.Reader) (io.Reader, error) { return snappy.NewReader(reader), nil }}
+	encNone = Encoding{
+		code:     0,
+		name:     "none",
+		readerFn: func(reader io.Reader) (io.Reader, error) { return reader, nil },
+		writerFn: func(w io.Writer) (io.WriteCloser, error) { return nopWriteCloser{w}, nil },
+	}
+	encGZIP = Encoding{
+		code:     1,
+		name:     "gzip",
+		readerFn: func(reader io.Reader) (io.Reader, error) { return gzip.NewReader(reader) },
+		writerFn: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+	}
+	encDumb = Encoding{
+		code:     2,
+		name:     "dumb",
+		readerFn: func(reader io.Reader) (io.Reader, error) { return reader, nil },
+		writerFn: func(w io.Writer) (io.WriteCloser, error) { return nopWriteCloser{w}, nil },
+	}
+	encLZ4 = Encoding{
+		code:     3,
+		name:     "lz4",
+		readerFn: func(reader io.Reader) (io.Reader, error) { return lz4.NewReader(reader), nil },
+		writerFn: func(w io.Writer) (io.WriteCloser, error) { return lz4.NewWriter(w), nil },
+	}
+	encSnappy = Encoding{
+		code:     4,
+		name:     "snappy",
+		readerFn: func(reader io.Reader) (io.Reader, error) { return snappy.NewReader(reader), nil },
+		writerFn: func(w io.Writer) (io.WriteCloser, error) { return snappy.NewWriter(w), nil },
+	}
+	encZstd = Encoding{
+		code: 5,
+		name: "zstd",
+		readerFn: func(reader io.Reader) (io.Reader, error) {
+			d, err := zstd.NewReader(reader)
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+		writerFn: func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) },
+	}
+	encFlate = Encoding{
+		code:     6,
+		name:     "flate",
+		readerFn: func(reader io.Reader) (io.Reader, error) { return flate.NewReader(reader), nil },
+		writerFn: func(w io.Writer) (io.WriteCloser, error) { return flate.NewWriter(w, flate.DefaultCompression) },
+	}
 
-	Encodings = []Encoding{encNone, encGZIP, encDumb, encLZ4, encSnappy}
+	// encodingRegistry maps the numeric code stored in the chunk header byte
+	// to the Encoding that knows how to read it. It is seeded with the
+	// built-in Loki encodings below, but third-party tools embedding this
+	// package as a library can add their own via RegisterEncoding.
+	encodingRegistry = map[int]Encoding{}
+
+	// Encodings lists every registered encoding: the built-ins in code order,
+	// then any added through RegisterEncoding in registration order (one that
+	// reuses an existing code takes that entry's place). Kept for callers
+	// (e.g. the benchmark mode) that want to iterate over every known codec
+	// rather than look one up by code.
+	Encodings []Encoding
 )
 
+func init() {
+	for _, e := range []Encoding{encNone, encGZIP, encDumb, encLZ4, encSnappy, encZstd, encFlate} {
+		registerEncoding(e)
+	}
+}
+
+// RegisterEncoding adds (or replaces) the Encoding used for a given chunk
+// header code. It lets third-party tools using this package as a library
+// plug in experimental or newer codecs without forking chunks-inspect.
+func RegisterEncoding(code int, name string, readerFn func(io.Reader) (io.Reader, error)) {
+	registerEncoding(Encoding{code: code, name: name, readerFn: readerFn})
+}
+
+func registerEncoding(e Encoding) {
+	encodingRegistry[e.code] = e
+
+	for i, existing := range Encodings {
+		if existing.code == e.code {
+			Encodings[i] = e
+			return
+		}
+	}
+	Encodings = append(Encodings, e)
+}
+
+// chunkSource is the minimal random-access surface parseLokiChunkSource
+// needs: read the footer, the block metadata and any given block's raw
+// bytes, without requiring the whole chunk body to be resident in memory.
+// An io.SectionReader bounding an open chunk file to its data region
+// satisfies it for parseLokiChunk; a *bytes.Reader over an already fully
+// loaded chunk (the path TranscodeChunk needs, since it reads and rewrites
+// every byte anyway) satisfies it too.
+type chunkSource interface {
+	io.ReaderAt
+	Size() int64
+}
+
 type LokiChunk struct {
-	encoding Encoding
+	encoding      Encoding
+	formatVersion byte
+
+	// src is where block bytes not yet decoded are read from on demand. Nil
+	// for chunks built directly from decoded data (there is currently no
+	// such path, but keep Entries' nil check honest if one is added).
+	src chunkSource
 
 	blocks []LokiBlock
 
@@ -60,7 +195,7 @@ type LokiBlock struct {
 	dataOffset uint64 // ofset in the data-part of chunks file
 	dataLength uint64 // length of raw data
 
-	rawData []byte // data as stored in chunk file, compressed
+	rawData []byte // data as stored in chunk file, compressed; nil until decoded
 
 	rawDataDigest          []byte // Digest (sha256) of compressed data
 	uncompressedDataDigest []byte // Digest (sha256) of uncompressed data
@@ -70,39 +205,118 @@ type LokiBlock struct {
 	uncompressedLength int
 	storedChecksum     uint32
 	computedChecksum   uint32
+
+	// decoded reports whether the fields above have been populated yet. A
+	// block stays undecoded until parseLokiChunkSource decodes it eagerly
+	// (because it overlaps the requested time range) or a caller reaches it
+	// through LokiChunk.Entries.
+	decoded bool
 }
 
 type LokiEntry struct {
 	timestamp int64
 	line      string
+
+	// StructuredMetadata holds the non-indexed labels attached to this entry.
+	// Only chunk format v4 and later carry it; it's nil on older chunks.
+	StructuredMetadata []labels.Label
 }
 
-func parseLokiChunk(chunkHeader *ChunkHeader, r io.Reader) (*LokiChunk, error) {
-	// Loki chunks need to be loaded into memory, because some offsets are actually stored at the end.
+// parseLokiChunk parses the Loki chunk body following chunkHeader in r. When
+// r also implements io.ReaderAt and io.Seeker (true for the *os.File every
+// caller in this tool passes), it parses lazily off an io.SectionReader
+// bounded to the chunk's data region: the footer and block metadata are
+// small ReadAt calls, and a block's bytes are only read (and decompressed)
+// once it overlaps the requested time range or a caller reaches it through
+// LokiChunk.Entries. Other callers fall back to reading the whole body into
+// memory first, since some offsets in the footer are relative to its end and
+// a plain io.Reader can't be read backwards.
+func parseLokiChunk(chunkHeader *ChunkHeader, r io.Reader, timeRange *TimeRange) (*LokiChunk, error) {
+	ra, isReaderAt := r.(io.ReaderAt)
+	s, isSeeker := r.(io.Seeker)
+	if isReaderAt && isSeeker {
+		base, err := s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate chunk data offset: %w", err)
+		}
+
+		chunk, err := parseLokiChunkSource(io.NewSectionReader(ra, base, int64(chunkHeader.DataLength)), timeRange)
+		if err != nil {
+			return nil, err
+		}
+
+		// Leave r positioned after the chunk body, as io.ReadFull would
+		// have, in case a caller reads another chunk off the same stream.
+		if _, err := s.Seek(base+int64(chunkHeader.DataLength), io.SeekStart); err != nil {
+			return nil, err
+		}
+		return chunk, nil
+	}
+
 	data := make([]byte, chunkHeader.DataLength)
 	if _, err := io.ReadFull(r, data); err != nil {
 		return nil, fmt.Errorf("failed to read rawData for Loki chunk into memory: %w", err)
 	}
+	return parseLokiChunkBytes(data, timeRange)
+}
 
-	if num := binary.BigEndian.Uint32(data[0:4]); num != 0x012EE56A {
+// parseLokiChunkBytes parses a Loki chunk already fully in memory. It's used
+// by parseLokiChunk's io.ReaderAt fallback and by TranscodeChunk (which
+// needs every block's entries, ignoring any block pruning, to re-encode
+// them).
+func parseLokiChunkBytes(data []byte, timeRange *TimeRange) (*LokiChunk, error) {
+	return parseLokiChunkSource(bytes.NewReader(data), timeRange)
+}
+
+// parseLokiChunkSource parses a chunk's header, footer and block metadata
+// off src, decoding each block eagerly only if it overlaps timeRange; blocks
+// it prunes are left undecoded until/unless LokiChunk.Entries asks for them.
+//
+// We don't have real v3/v4 fixtures or an upstream spec reference to confirm
+// their block/footer layout, so all four formats are parsed with the same
+// 6-byte-header-plus-footer layout as v2; only the per-entry body differs
+// (v4+ carries structured metadata, handled in parseLokiBlock). This is a
+// documented assumption, not a verified fact about those formats: if a real
+// v3/v4 chunk's footer layout turns out to differ, MetadataChecksumOK (and
+// the per-block checksum fields) will come back false rather than the tool
+// silently trusting wrong block offsets.
+func parseLokiChunkSource(src chunkSource, timeRange *TimeRange) (*LokiChunk, error) {
+	var header [6]byte
+	if _, err := src.ReadAt(header[:], 0); err != nil {
+		return nil, fmt.Errorf("failed to read chunk header: %w", err)
+	}
+	if num := binary.BigEndian.Uint32(header[0:4]); num != 0x012EE56A {
 		return nil, fmt.Errorf("invalid magic number: %0x", num)
 	}
 
-	compression, err := getCompression(data[4], data[5])
+	format := header[4]
+	switch format {
+	case chunkFormatV1, chunkFormatV2, chunkFormatV3, chunkFormatV4:
+	default:
+		return nil, fmt.Errorf("failed to read compression: %w", UnsupportedCompressionError{format: format})
+	}
+
+	compression, err := getCompression(format, header[5])
 	if err != nil {
 		return nil, fmt.Errorf("failed to read compression: %w", err)
 	}
 
-	// return &LokiChunk{encoding: compression}, nil
-
-	metasOffset := binary.BigEndian.Uint64(data[len(data)-8:])
+	size := src.Size()
 
-	metadata := data[metasOffset : len(data)-(8+4)]
+	var footer [12]byte
+	if _, err := src.ReadAt(footer[:], size-12); err != nil {
+		return nil, fmt.Errorf("failed to read chunk footer: %w", err)
+	}
+	metaChecksum := binary.BigEndian.Uint32(footer[0:4])
+	metasOffset := binary.BigEndian.Uint64(footer[4:12])
 
-	metaChecksum := binary.BigEndian.Uint32(data[len(data)-12 : len(data)-8])
+	metadata := make([]byte, size-12-int64(metasOffset))
+	if _, err := src.ReadAt(metadata, int64(metasOffset)); err != nil {
+		return nil, fmt.Errorf("failed to read block metadata: %w", err)
+	}
 	computedMetaChecksum := crc32.Checksum(metadata, castagnoliTable)
 
-	blocks, n := binary.Uvarint(metadata)
+	numBlocks, n := binary.Uvarint(metadata)
 	if n <= 0 {
 		return nil, fmt.Errorf("failed to read number of blocks")
 	}
@@ -110,11 +324,13 @@ func parseLokiChunk(chunkHeader *ChunkHeader, r io.Reader) (*LokiChunk, error) {
 
 	lokiChunk := &LokiChunk{
 		encoding:                 compression,
+		formatVersion:            format,
+		src:                      src,
 		metadataChecksum:         metaChecksum,
 		computedMetadataChecksum: computedMetaChecksum,
 	}
 
-	for ix := 0; ix < int(blocks); ix++ {
+	for ix := 0; ix < int(numBlocks); ix++ {
 		block := LokiBlock{}
 		block.numEntries, metadata, err = readUvarint(err, metadata)
 		block.minT, metadata, err = readVarint(err, metadata)
@@ -126,56 +342,325 @@ func parseLokiChunk(chunkHeader *ChunkHeader, r io.Reader) (*LokiChunk, error) {
 			return nil, err
 		}
 
-		block.rawData = data[block.dataOffset : block.dataOffset+block.dataLength]
-		d := sha256.Sum256(block.rawData)
-		block.rawDataDigest = d[:]
-		block.storedChecksum = binary.BigEndian.Uint32(data[block.dataOffset+block.dataLength : block.dataOffset+block.dataLength+4])
-		block.computedChecksum = crc32.Checksum(block.rawData, castagnoliTable)
-		block.uncompressedLength, block.entries, block.uncompressedDataDigest, err = parseLokiBlock(compression, block.rawData)
+		// Blocks entirely outside the requested time range are never even
+		// read off src, let alone decompressed: minT/maxT come from the
+		// metadata footer we already have in hand, so we can prune before
+		// paying for either. Pruned blocks stay undecoded until a caller
+		// asks for them explicitly through Entries.
+		if timeRange.Overlaps(block.minT, block.maxT) {
+			if err := lokiChunk.decodeBlock(&block); err != nil {
+				return nil, err
+			}
+		}
 		lokiChunk.blocks = append(lokiChunk.blocks, block)
 	}
 
 	return lokiChunk, nil
 }
 
-func parseLokiBlock(compression Encoding, data []byte) (int, []LokiEntry, []byte, error) {
-	r, err := compression.readerFn(bytes.NewReader(data))
+// decodeBlock reads block b's compressed bytes off c.src and decodes its
+// entries, populating its digest, checksum and entries fields. It's the only
+// place that pays to read and decompress a block, and it's idempotent so
+// parseLokiChunkSource's eager pruning pass and a later Entries call never
+// do the work twice.
+func (c *LokiChunk) decodeBlock(b *LokiBlock) error {
+	if b.decoded {
+		return nil
+	}
+
+	// b.dataLength comes straight from the untrusted metadata footer: a
+	// corrupted or adversarial chunk can claim an arbitrarily large block
+	// and turn make([]byte, b.dataLength) into a fatal, unrecoverable OOM
+	// (the same failure mode readStructuredMetadata guards against for
+	// numLabels). Bound it against the actual source size first.
+	srcSize := uint64(c.src.Size())
+	if b.dataLength > srcSize || b.dataOffset > srcSize-b.dataLength {
+		return fmt.Errorf("block data (offset %d, length %d) extends past end of chunk (%d bytes)", b.dataOffset, b.dataLength, srcSize)
+	}
+
+	rawData := make([]byte, b.dataLength)
+	if _, err := c.src.ReadAt(rawData, int64(b.dataOffset)); err != nil {
+		return fmt.Errorf("failed to read block data: %w", err)
+	}
+	b.rawData = rawData
+
+	d := sha256.Sum256(rawData)
+	b.rawDataDigest = d[:]
+	b.computedChecksum = crc32.Checksum(rawData, castagnoliTable)
+
+	var checksumBuf [4]byte
+	if _, err := c.src.ReadAt(checksumBuf[:], int64(b.dataOffset+b.dataLength)); err != nil {
+		return fmt.Errorf("failed to read block checksum: %w", err)
+	}
+	b.storedChecksum = binary.BigEndian.Uint32(checksumBuf[:])
+
+	uncompressedLength, entries, digest, err := parseLokiBlock(c.encoding, rawData, c.formatVersion)
 	if err != nil {
-		return 0, nil, nil, err
+		return err
 	}
+	b.uncompressedLength = uncompressedLength
+	b.entries = entries
+	b.uncompressedDataDigest = digest
+	b.decoded = true
 
-	decompressed, err := ioutil.ReadAll(r)
+	return nil
+}
+
+// decodeAllBlocks forces every block in the chunk to be decoded, including
+// ones parseLokiChunkSource left pruned because they fell outside the
+// requested --from/--to range. Callers that report a checksum/digest/ratio
+// for every block unconditionally (the -b and -o json block tables) need
+// this first: a pruned block's fields are all zero value, and 0 == 0 would
+// otherwise render as a verified-OK checksum it never actually read.
+func (c *LokiChunk) decodeAllBlocks() error {
+	for ix := range c.blocks {
+		if err := c.decodeBlock(&c.blocks[ix]); err != nil {
+			return fmt.Errorf("block %d: %w", ix, err)
+		}
+	}
+	return nil
+}
+
+// parseLokiBlock decodes one block's entries. Rather than materialising the
+// whole decompressed block with ioutil.ReadAll before walking it (the old
+// approach, which kept two full copies of the block alive at once), it reads
+// the decompressor's output through a varintReader: a small buffer that's
+// refilled only when fewer than binary.MaxVarintLen64 bytes remain (or, for a
+// line or label string, fewer than that string's length), mirroring
+// the sliding-window decode Loki's own bufferedIterator uses.
+//
+// On format v4+ chunks, every entry is followed by its structured metadata
+// (non-indexed labels): a uvarint label count, then for each label a
+// uvarint-length-prefixed name and value.
+func parseLokiBlock(compression Encoding, data []byte, format byte) (int, []LokiEntry, []byte, error) {
+	r, err := compression.readerFn(bytes.NewReader(data))
 	if err != nil {
 		return 0, nil, nil, err
 	}
 
-	digest := sha256.Sum256(decompressed)
+	hasher := sha256.New()
+	vr := newVarintReader(io.TeeReader(r, hasher))
 
 	entries := []LokiEntry(nil)
-	decompressedLen := len(decompressed)
-	for len(decompressed) > 0 {
-		var timestamp int64
-		var lineLength uint64
+	decompressedLen := 0
 
-		timestamp, decompressed, err = readVarint(err, decompressed)
-		lineLength, decompressed, err = readUvarint(err, decompressed)
+	for {
+		timestamp, n, err := vr.readVarint()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return 0, nil, digest[:], err
+			return 0, nil, nil, err
 		}
+		decompressedLen += n
 
-		if len(decompressed) < int(lineLength) {
-			return 0, nil, digest[:], fmt.Errorf("not enough line data, need %d, got %d", lineLength, len(decompressed))
+		lineLength, n, err := vr.readUvarint()
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("failed to read line length: %w", err)
 		}
+		decompressedLen += n
 
-		entries = append(entries, LokiEntry{
-			timestamp: timestamp,
-			line:      string(decompressed[0:lineLength]),
-		})
+		line, err := vr.readString(int(lineLength))
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("failed to read line: %w", err)
+		}
+		decompressedLen += int(lineLength)
+
+		entry := LokiEntry{timestamp: timestamp, line: line}
+
+		if format >= chunkFormatV4 {
+			entry.StructuredMetadata, n, err = readStructuredMetadata(vr)
+			if err != nil {
+				return 0, nil, nil, fmt.Errorf("failed to read structured metadata: %w", err)
+			}
+			decompressedLen += n
+		}
+
+		entries = append(entries, entry)
+	}
+
+	digest := hasher.Sum(nil)
+	return decompressedLen, entries, digest, nil
+}
+
+// readStructuredMetadata reads one entry's non-indexed labels off vr: a
+// uvarint count followed by that many length-prefixed name/value pairs. It
+// returns the number of bytes consumed alongside the labels so callers can
+// fold it into their own decompressedLen tally.
+func readStructuredMetadata(vr *varintReader) ([]labels.Label, int, error) {
+	numLabels, n, err := vr.readUvarint()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read label count: %w", err)
+	}
+	consumed := n
+
+	if numLabels == 0 {
+		return nil, consumed, nil
+	}
+
+	// numLabels comes straight off the chunk bytes, so a corrupted or
+	// adversarial file could claim billions of labels. Don't preallocate a
+	// slice from it directly: make([]T, 0, hugeN) can trigger a fatal
+	// "out of memory" that recover() cannot catch and kills the whole
+	// process, which is unacceptable in a tool meant to batch-scan
+	// arbitrary production chunks. append grows incrementally instead, and
+	// the loop itself is bounded by vr actually having that many labels to
+	// read off the underlying reader.
+	var metadata []labels.Label
+	for i := uint64(0); i < numLabels; i++ {
+		nameLength, n, err := vr.readUvarint()
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read label name length: %w", err)
+		}
+		consumed += n
+
+		name, err := vr.readString(int(nameLength))
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read label name: %w", err)
+		}
+		consumed += int(nameLength)
+
+		valueLength, n, err := vr.readUvarint()
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read label value length: %w", err)
+		}
+		consumed += n
+
+		value, err := vr.readString(int(valueLength))
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read label value: %w", err)
+		}
+		consumed += int(valueLength)
+
+		metadata = append(metadata, labels.Label{Name: name, Value: value})
+	}
+
+	return metadata, consumed, nil
+}
+
+// varintReader decodes a stream of varint-prefixed records off r through a
+// small buffer, requesting more data from r only when the buffer holds fewer
+// than binary.MaxVarintLen64 unconsumed bytes (readString: fewer than the
+// requested length).
+type varintReader struct {
+	r   io.Reader
+	buf []byte // unconsumed bytes, buf[0] is the next byte to decode
+	eof bool
+}
+
+func newVarintReader(r io.Reader) *varintReader {
+	return &varintReader{r: r, buf: make([]byte, 0, 4096)}
+}
+
+// fill tops the buffer up so it holds at least n unconsumed bytes, unless r
+// is exhausted first.
+func (v *varintReader) fill(n int) error {
+	for len(v.buf) < n && !v.eof {
+		if cap(v.buf)-len(v.buf) < 4096 {
+			grown := make([]byte, len(v.buf), len(v.buf)+4096)
+			copy(grown, v.buf)
+			v.buf = grown
+		}
 
-		decompressed = decompressed[lineLength:]
+		readInto := v.buf[len(v.buf):cap(v.buf)]
+		read, err := v.r.Read(readInto)
+		v.buf = v.buf[:len(v.buf)+read]
+		if err != nil {
+			if err != io.EOF {
+				return err
+			}
+			v.eof = true
+		}
+	}
+	return nil
+}
+
+func (v *varintReader) readVarint() (int64, int, error) {
+	if err := v.fill(binary.MaxVarintLen64); err != nil {
+		return 0, 0, err
+	}
+	if len(v.buf) == 0 {
+		return 0, 0, io.EOF
 	}
 
-	return decompressedLen, entries, digest[:], nil
+	val, n := binary.Varint(v.buf)
+	if n <= 0 {
+		return 0, 0, fmt.Errorf("invalid varint")
+	}
+	v.buf = v.buf[n:]
+	return val, n, nil
+}
+
+func (v *varintReader) readUvarint() (uint64, int, error) {
+	if err := v.fill(binary.MaxVarintLen64); err != nil {
+		return 0, 0, err
+	}
+	if len(v.buf) == 0 {
+		return 0, 0, io.EOF
+	}
+
+	val, n := binary.Uvarint(v.buf)
+	if n <= 0 {
+		return 0, 0, fmt.Errorf("invalid uvarint")
+	}
+	v.buf = v.buf[n:]
+	return val, n, nil
+}
+
+func (v *varintReader) readString(n int) (string, error) {
+	if err := v.fill(n); err != nil {
+		return "", err
+	}
+	if len(v.buf) < n {
+		return "", fmt.Errorf("not enough line data, need %d, got %d", n, len(v.buf))
+	}
+
+	s := string(v.buf[:n])
+	v.buf = v.buf[n:]
+	return s, nil
+}
+
+// EntryIter streams a single block's entries without retaining the block's
+// whole decompressed buffer, for callers (such as -l on a single block, or a
+// library caller) that only want one block out of a large chunk.
+type EntryIter struct {
+	entries []LokiEntry
+	pos     int
+}
+
+// Entries returns an iterator over a single block's entries, decoding that
+// block off the chunk source on first access (and caching the result on the
+// block itself) if it wasn't already decoded during parsing. Other blocks
+// are never touched, so scanning one block of a multi-GB chunk doesn't pay
+// to decompress the rest.
+func (c *LokiChunk) Entries(blockIdx int) (*EntryIter, error) {
+	if blockIdx < 0 || blockIdx >= len(c.blocks) {
+		return nil, fmt.Errorf("block index %d out of range (chunk has %d blocks)", blockIdx, len(c.blocks))
+	}
+
+	b := &c.blocks[blockIdx]
+	if !b.decoded {
+		if c.src == nil {
+			return nil, fmt.Errorf("block %d was not decoded and chunk has no source to decode it from", blockIdx)
+		}
+		if err := c.decodeBlock(b); err != nil {
+			return nil, err
+		}
+	}
+
+	return &EntryIter{entries: b.entries, pos: -1}, nil
+}
+
+// Next advances the iterator and reports whether an entry is available.
+func (it *EntryIter) Next() bool {
+	it.pos++
+	return it.pos < len(it.entries)
+}
+
+// Entry returns the entry at the iterator's current position. Only valid
+// after a call to Next that returned true.
+func (it *EntryIter) Entry() LokiEntry {
+	return it.entries[it.pos]
 }
 
 func readVarint(prevErr error, buf []byte) (int64, []byte, error) {
@@ -203,19 +688,17 @@ func readUvarint(prevErr error, buf []byte) (uint64, []byte, error) {
 }
 
 func getCompression(format byte, code byte) (Encoding, error) {
-	if format == 1 {
+	if format == chunkFormatV1 {
 		return encGZIP, nil
 	}
 
-	if format == 2 {
-		for _, e := range Encodings {
-			if e.code == int(code) {
-				return e, nil
-			}
+	if format == chunkFormatV2 || format == chunkFormatV3 || format == chunkFormatV4 {
+		if e, ok := encodingRegistry[int(code)]; ok {
+			return e, nil
 		}
 
-		return encNone, fmt.Errorf("unknown encoding: %d", code)
+		return encNone, UnsupportedCompressionError{format: format, code: code, known: true}
 	}
 
-	return encNone, fmt.Errorf("unknown format: %d", format)
+	return encNone, UnsupportedCompressionError{format: format}
 }
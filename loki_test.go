@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+func putTestUvarint(buf *bytes.Buffer, v uint64) {
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(scratch[:], v)
+	buf.Write(scratch[:n])
+}
+
+func putTestVarint(buf *bytes.Buffer, v int64) {
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(scratch[:], v)
+	buf.Write(scratch[:n])
+}
+
+func writeTestEntry(buf *bytes.Buffer, ts int64, line string, md []labels.Label, withMetadata bool) {
+	putTestVarint(buf, ts)
+	putTestUvarint(buf, uint64(len(line)))
+	buf.WriteString(line)
+
+	if !withMetadata {
+		return
+	}
+
+	putTestUvarint(buf, uint64(len(md)))
+	for _, l := range md {
+		putTestUvarint(buf, uint64(len(l.Name)))
+		buf.WriteString(l.Name)
+		putTestUvarint(buf, uint64(len(l.Value)))
+		buf.WriteString(l.Value)
+	}
+}
+
+func TestParseLokiBlockV2HasNoStructuredMetadata(t *testing.T) {
+	var buf bytes.Buffer
+	writeTestEntry(&buf, 1000, "hello", nil, false)
+	writeTestEntry(&buf, 2000, "world", nil, false)
+
+	length, entries, digest, err := parseLokiBlock(encNone, buf.Bytes(), chunkFormatV2)
+	if err != nil {
+		t.Fatalf("parseLokiBlock: %v", err)
+	}
+	if length != buf.Len() {
+		t.Errorf("uncompressedLength = %d, want %d", length, buf.Len())
+	}
+	if len(digest) == 0 {
+		t.Error("digest is empty")
+	}
+	if len(entries) != 2 {
+		t.Fatalf("len(entries) = %d, want 2", len(entries))
+	}
+	if entries[0].timestamp != 1000 || entries[0].line != "hello" {
+		t.Errorf("entries[0] = %+v", entries[0])
+	}
+	if entries[1].timestamp != 2000 || entries[1].line != "world" {
+		t.Errorf("entries[1] = %+v", entries[1])
+	}
+	if len(entries[0].StructuredMetadata) != 0 {
+		t.Errorf("v2 entry got structured metadata: %+v", entries[0].StructuredMetadata)
+	}
+}
+
+func TestParseLokiBlockV4StructuredMetadata(t *testing.T) {
+	md := []labels.Label{{Name: "pod", Value: "foo-123"}, {Name: "trace_id", Value: "abc"}}
+
+	var buf bytes.Buffer
+	writeTestEntry(&buf, 1000, "hello", md, true)
+	writeTestEntry(&buf, 2000, "world", nil, true)
+
+	_, entries, _, err := parseLokiBlock(encNone, buf.Bytes(), chunkFormatV4)
+	if err != nil {
+		t.Fatalf("parseLokiBlock: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("len(entries) = %d, want 2", len(entries))
+	}
+
+	if len(entries[0].StructuredMetadata) != 2 {
+		t.Fatalf("entries[0].StructuredMetadata = %+v, want 2 labels", entries[0].StructuredMetadata)
+	}
+	for i, want := range md {
+		if entries[0].StructuredMetadata[i] != want {
+			t.Errorf("entries[0].StructuredMetadata[%d] = %+v, want %+v", i, entries[0].StructuredMetadata[i], want)
+		}
+	}
+
+	if len(entries[1].StructuredMetadata) != 0 {
+		t.Errorf("entries[1].StructuredMetadata = %+v, want none", entries[1].StructuredMetadata)
+	}
+}
@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"regexp"
 	"strings"
 	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
 )
 
 const format = "2006-01-02 15:04:05.000000 MST"
@@ -16,14 +19,94 @@ var timezone = time.UTC
 func main() {
 	blocks := flag.Bool("b", false, "print block details")
 	lines := flag.Bool("l", false, "print log lines")
+	output := flag.String("o", "text", "output format: text, json or ndjson")
+	from := flag.String("from", "", "only look at entries at or after this time (RFC3339 or unix seconds)")
+	to := flag.String("to", "", "only look at entries at or before this time (RFC3339 or unix seconds)")
+	match := flag.String("match", "", `only look at chunks whose labels include a match, e.g. --match 'label="value"'`)
+	lineRegexp := flag.String("line-regexp", "", "only print entries whose line matches this regexp")
+	transcodeTo := flag.String("transcode", "", "re-encode the chunk's blocks with this encoding (e.g. zstd) instead of inspecting it")
+	transcodeOut := flag.String("out", "", "output path for -transcode (required with -transcode, one input file only)")
+	benchmark := flag.Bool("benchmark", false, "compress each block with every known encoding and report ratio/latency")
 	flag.Parse()
 
+	if *transcodeTo != "" {
+		args := flag.Args()
+		if len(args) != 1 || *transcodeOut == "" {
+			log.Fatalf("-transcode requires exactly one input file and -out")
+		}
+		if err := runTranscode(args[0], *transcodeTo, *transcodeOut); err != nil {
+			log.Fatalf("%s: %v", args[0], err)
+		}
+		return
+	}
+
+	switch *output {
+	case "text", "json", "ndjson":
+	default:
+		log.Fatalf("unknown output format %q, must be one of: text, json, ndjson", *output)
+	}
+
+	var timeRange *TimeRange
+	if *from != "" || *to != "" {
+		timeRange = &TimeRange{}
+		if *from != "" {
+			ns, err := parseTimeFlag(*from)
+			if err != nil {
+				log.Fatalf("--from: %v", err)
+			}
+			timeRange.From = &ns
+		}
+		if *to != "" {
+			ns, err := parseTimeFlag(*to)
+			if err != nil {
+				log.Fatalf("--to: %v", err)
+			}
+			timeRange.To = &ns
+		}
+	}
+
+	var matcher *LabelMatcher
+	if *match != "" {
+		m, err := parseLabelMatcher(*match)
+		if err != nil {
+			log.Fatalf("--match: %v", err)
+		}
+		matcher = m
+	}
+
+	var lineRe *regexp.Regexp
+	if *lineRegexp != "" {
+		re, err := regexp.Compile(*lineRegexp)
+		if err != nil {
+			log.Fatalf("--line-regexp: %v", err)
+		}
+		lineRe = re
+	}
+
 	for _, f := range flag.Args() {
-		printFile(f, *blocks, *lines)
+		if *benchmark {
+			if err := runBenchmark(f, *output == "json"); err != nil {
+				log.Printf("%s: %v", f, err)
+			}
+			continue
+		}
+
+		switch *output {
+		case "json":
+			if err := printFileJSON(f, *lines, timeRange, matcher, lineRe); err != nil {
+				log.Printf("%s: %v", f, err)
+			}
+		case "ndjson":
+			if err := printFileNDJSON(f, timeRange, matcher, lineRe); err != nil {
+				log.Printf("%s: %v", f, err)
+			}
+		default:
+			printFile(f, *blocks, *lines, timeRange, matcher, lineRe)
+		}
 	}
 }
 
-func printFile(filename string, blockDetails, printLines bool) {
+func printFile(filename string, blockDetails, printLines bool, timeRange *TimeRange, matcher *LabelMatcher, lineRe *regexp.Regexp) {
 	f, err := os.Open(filename)
 	if err != nil {
 		log.Printf("%s: %v", filename, err)
@@ -43,6 +126,10 @@ func printFile(filename string, blockDetails, printLines bool) {
 		return
 	}
 
+	if matcher != nil && !matcher.Matches(h) {
+		return
+	}
+
 	fmt.Println()
 	fmt.Println("Chunks file:", filename)
 	fmt.Println("Metadata length:", h.MetadataLength)
@@ -57,12 +144,23 @@ func printFile(filename string, blockDetails, printLines bool) {
 		fmt.Println("\t", l.Name, "=", l.Value)
 	}
 
-	lokiChunk, err := parseLokiChunk(h, f)
+	lokiChunk, err := parseLokiChunk(h, f, timeRange)
 	if err != nil {
 		log.Printf("%s: %v", filename, err)
 		return
 	}
 
+	if blockDetails {
+		// -b prints every block's checksum/digest fields unconditionally,
+		// but timeRange pruning leaves those at their zero value on blocks
+		// it skipped — which a naive == comparison would otherwise report
+		// as a false "OK". Force every block to be decoded first.
+		if err := lokiChunk.decodeAllBlocks(); err != nil {
+			log.Printf("%s: %v", filename, err)
+			return
+		}
+	}
+
 	fmt.Println("Encoding:", lokiChunk.encoding)
 	fmt.Print("Blocks Metadata Checksum: ", fmt.Sprintf("%08x", lokiChunk.metadataChecksum))
 	if lokiChunk.metadataChecksum == lokiChunk.computedMetadataChecksum {
@@ -97,11 +195,26 @@ func printFile(filename string, blockDetails, printLines bool) {
 		totalSize += b.uncompressedLength
 
 		if printLines {
-			for _, l := range b.entries {
-				fmt.Printf("%v\t%s\n", time.Unix(0, l.timestamp).In(timezone).Format(format), strings.TrimSpace(l.line))
+			for _, l := range filterEntries(b.entries, timeRange, lineRe) {
+				fmt.Printf("%v\t%s%s\n", time.Unix(0, l.timestamp).In(timezone).Format(format), strings.TrimSpace(l.line), formatStructuredMetadata(l.StructuredMetadata))
 			}
 		}
 	}
 
 	fmt.Println("Total size of uncompressed data:", totalSize, "file size:", si.Size(), "ratio:", fmt.Sprintf("%0.3g", float64(totalSize)/float64(si.Size())))
 }
+
+// formatStructuredMetadata renders an entry's non-indexed labels (v4+ chunks
+// only) as a trailing ` {name="value", ...}` suffix, or "" if the entry has
+// none.
+func formatStructuredMetadata(md []labels.Label) string {
+	if len(md) == 0 {
+		return ""
+	}
+
+	parts := make([]string, 0, len(md))
+	for _, l := range md {
+		parts = append(parts, fmt.Sprintf("%s=%q", l.Name, l.Value))
+	}
+	return " {" + strings.Join(parts, ", ") + "}"
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// chunkReportJSON is the `-o json` representation of a chunks file: the
+// chunk header, the block table and, if requested, every entry. It mirrors
+// the fields printed by printFile, but as a single machine-readable document.
+type chunkReportJSON struct {
+	Filename       string            `json:"filename"`
+	MetadataLength uint32            `json:"metadata_length"`
+	DataLength     uint32            `json:"data_length"`
+	UserID         string            `json:"user_id"`
+	From           int64             `json:"from_unix_nano"`
+	Through        int64             `json:"through_unix_nano"`
+	Labels         map[string]string `json:"labels"`
+	Encoding       string            `json:"encoding"`
+
+	MetadataChecksum         string `json:"metadata_checksum"`
+	ComputedMetadataChecksum string `json:"computed_metadata_checksum"`
+	MetadataChecksumOK       bool   `json:"metadata_checksum_ok"`
+
+	Blocks []blockReportJSON `json:"blocks"`
+
+	TotalUncompressedSize int `json:"total_uncompressed_size"`
+}
+
+type blockReportJSON struct {
+	Index int `json:"index"`
+
+	DataOffset         uint64  `json:"data_offset"`
+	CompressedLength   uint64  `json:"compressed_length"`
+	UncompressedLength int     `json:"uncompressed_length"`
+	Ratio              float64 `json:"ratio"`
+
+	MinT int64 `json:"min_t_unix_nano"`
+	MaxT int64 `json:"max_t_unix_nano"`
+
+	StoredChecksum   string `json:"stored_checksum"`
+	ComputedChecksum string `json:"computed_checksum"`
+	ChecksumOK       bool   `json:"checksum_ok"`
+
+	CompressedDigest   string `json:"compressed_sha256"`
+	UncompressedDigest string `json:"uncompressed_sha256"`
+
+	Entries []entryJSON `json:"entries,omitempty"`
+}
+
+// entryJSON is also the shape streamed, one per line, by `-o ndjson`.
+type entryJSON struct {
+	Timestamp          int64             `json:"ts"`
+	Line               string            `json:"line"`
+	Block              int               `json:"block"`
+	StructuredMetadata map[string]string `json:"structured_metadata,omitempty"`
+}
+
+// newEntryJSON builds the JSON record for one entry, nesting its structured
+// metadata (v4+ chunks only) as a name->value object.
+func newEntryJSON(e LokiEntry, block int) entryJSON {
+	rec := entryJSON{Timestamp: e.timestamp, Line: e.line, Block: block}
+	if len(e.StructuredMetadata) > 0 {
+		rec.StructuredMetadata = make(map[string]string, len(e.StructuredMetadata))
+		for _, l := range e.StructuredMetadata {
+			rec.StructuredMetadata[l.Name] = l.Value
+		}
+	}
+	return rec
+}
+
+func buildChunkReportJSON(filename string, h *ChunkHeader, lokiChunk *LokiChunk, includeEntries bool, timeRange *TimeRange, lineRe *regexp.Regexp) *chunkReportJSON {
+	labels := make(map[string]string, len(h.Metric))
+	for _, l := range h.Metric {
+		labels[l.Name] = l.Value
+	}
+
+	report := &chunkReportJSON{
+		Filename:       filename,
+		MetadataLength: h.MetadataLength,
+		DataLength:     h.DataLength,
+		UserID:         h.UserID,
+		From:           h.From.Time().UnixNano(),
+		Through:        h.Through.Time().UnixNano(),
+		Labels:         labels,
+		Encoding:       lokiChunk.encoding.String(),
+
+		MetadataChecksum:         fmt.Sprintf("%08x", lokiChunk.metadataChecksum),
+		ComputedMetadataChecksum: fmt.Sprintf("%08x", lokiChunk.computedMetadataChecksum),
+		MetadataChecksumOK:       lokiChunk.metadataChecksum == lokiChunk.computedMetadataChecksum,
+	}
+
+	for ix, b := range lokiChunk.blocks {
+		block := blockReportJSON{
+			Index:              ix,
+			DataOffset:         b.dataOffset,
+			CompressedLength:   b.dataLength,
+			UncompressedLength: b.uncompressedLength,
+			Ratio:              float64(b.uncompressedLength) / float64(b.dataLength),
+			MinT:               b.minT,
+			MaxT:               b.maxT,
+			StoredChecksum:     fmt.Sprintf("%08x", b.storedChecksum),
+			ComputedChecksum:   fmt.Sprintf("%08x", b.computedChecksum),
+			ChecksumOK:         b.storedChecksum == b.computedChecksum,
+			CompressedDigest:   fmt.Sprintf("%x", b.rawDataDigest),
+			UncompressedDigest: fmt.Sprintf("%x", b.uncompressedDataDigest),
+		}
+
+		if includeEntries {
+			for _, e := range filterEntries(b.entries, timeRange, lineRe) {
+				block.Entries = append(block.Entries, newEntryJSON(e, ix))
+			}
+		}
+
+		report.TotalUncompressedSize += b.uncompressedLength
+		report.Blocks = append(report.Blocks, block)
+	}
+
+	return report
+}
+
+func printFileJSON(filename string, includeEntries bool, timeRange *TimeRange, matcher *LabelMatcher, lineRe *regexp.Regexp) error {
+	h, lokiChunk, skipped, err := readLokiChunkFile(filename, timeRange, matcher)
+	if err != nil {
+		return err
+	}
+	if skipped {
+		return nil
+	}
+
+	// buildChunkReportJSON's block table reports a checksum/digest/ratio for
+	// every block regardless of includeEntries, so any block timeRange left
+	// pruned (decoded=false) needs decoding now or its zero-valued fields
+	// would be reported as a false "OK".
+	if err := lokiChunk.decodeAllBlocks(); err != nil {
+		return err
+	}
+
+	report := buildChunkReportJSON(filename, h, lokiChunk, includeEntries, timeRange, lineRe)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+func printFileNDJSON(filename string, timeRange *TimeRange, matcher *LabelMatcher, lineRe *regexp.Regexp) error {
+	_, lokiChunk, skipped, err := readLokiChunkFile(filename, timeRange, matcher)
+	if err != nil {
+		return err
+	}
+	if skipped {
+		return nil
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for ix, b := range lokiChunk.blocks {
+		for _, e := range filterEntries(b.entries, timeRange, lineRe) {
+			if err := enc.Encode(newEntryJSON(e, ix)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readLokiChunkFile opens filename, decodes its header and parses the Loki
+// chunk it contains. It factors out the open/decode/parse steps shared by
+// the json and ndjson output modes and -benchmark; the text mode (printFile)
+// does these steps itself. The bool return reports whether
+// the file was skipped because matcher didn't match its labels.
+func readLokiChunkFile(filename string, timeRange *TimeRange, matcher *LabelMatcher) (*ChunkHeader, *LokiChunk, bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	defer f.Close()
+
+	h, err := DecodeHeader(f)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if matcher != nil && !matcher.Matches(h) {
+		return nil, nil, true, nil
+	}
+
+	lokiChunk, err := parseLokiChunk(h, f, timeRange)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	return h, lokiChunk, false, nil
+}
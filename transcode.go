@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// TranscodeChunk reads a full Loki chunk from in, decodes every block, and
+// rewrites it to out using target as the block compression codec. Block
+// boundaries (one entry set per original block), per-entry timestamps, and
+// the metadata-footer layout that parseLokiChunk validates are preserved;
+// what changes is the header's format and encoding-code bytes and each
+// block's compressed payload, with its checksum and offset recomputed to
+// match. The output is
+// always written as chunk format 2, so it can't carry v4+ structured
+// metadata; TranscodeChunk refuses chunks that have any rather than
+// silently dropping it.
+func TranscodeChunk(in io.Reader, out io.Writer, target Encoding) error {
+	if target.writerFn == nil {
+		// Encodings added through the public RegisterEncoding hook only
+		// carry a readerFn (it's a decode-only registration), so they
+		// can't be used as a -transcode target.
+		return fmt.Errorf("encoding %q has no writer, can't be used as a -transcode target", target.name)
+	}
+
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return fmt.Errorf("failed to read chunk: %w", err)
+	}
+
+	chunk, err := parseLokiChunkBytes(data, nil)
+	if err != nil {
+		return fmt.Errorf("failed to parse chunk: %w", err)
+	}
+
+	// The output below is always written as format 2 (see body.WriteByte(2)
+	// below), which has no structured-metadata section. entriesToBytes
+	// silently drops it, so refuse up front rather than losing it without
+	// telling the caller.
+	for ix, b := range chunk.blocks {
+		for _, e := range b.entries {
+			if len(e.StructuredMetadata) > 0 {
+				return fmt.Errorf("block %d has structured metadata (chunk format %d); -transcode doesn't yet preserve it in its format-2 output", ix, chunk.formatVersion)
+			}
+		}
+	}
+
+	var body bytes.Buffer
+	body.Write(data[0:4]) // magic number
+	body.WriteByte(2)     // format: code byte follows
+	body.WriteByte(byte(target.code))
+
+	type blockMeta struct {
+		numEntries uint64
+		minT, maxT int64
+		offset     uint64
+		length     uint64
+	}
+	metas := make([]blockMeta, 0, len(chunk.blocks))
+
+	for _, b := range chunk.blocks {
+		offset := uint64(body.Len())
+
+		var compressed bytes.Buffer
+		w, err := target.writerFn(&compressed)
+		if err != nil {
+			return fmt.Errorf("failed to create %s writer: %w", target, err)
+		}
+		if _, err := w.Write(entriesToBytes(b.entries)); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("failed to finalize %s block: %w", target, err)
+		}
+
+		body.Write(compressed.Bytes())
+		checksum := crc32.Checksum(compressed.Bytes(), castagnoliTable)
+		if err := binary.Write(&body, binary.BigEndian, checksum); err != nil {
+			return err
+		}
+
+		metas = append(metas, blockMeta{
+			numEntries: b.numEntries,
+			minT:       b.minT,
+			maxT:       b.maxT,
+			offset:     offset,
+			length:     uint64(compressed.Len()),
+		})
+	}
+
+	metasOffset := uint64(body.Len())
+
+	var metadata bytes.Buffer
+	putUvarint(&metadata, uint64(len(metas)))
+	for _, m := range metas {
+		putUvarint(&metadata, m.numEntries)
+		putVarint(&metadata, m.minT)
+		putVarint(&metadata, m.maxT)
+		putUvarint(&metadata, m.offset)
+		putUvarint(&metadata, m.length)
+	}
+
+	body.Write(metadata.Bytes())
+	metaChecksum := crc32.Checksum(metadata.Bytes(), castagnoliTable)
+	if err := binary.Write(&body, binary.BigEndian, metaChecksum); err != nil {
+		return err
+	}
+	if err := binary.Write(&body, binary.BigEndian, metasOffset); err != nil {
+		return err
+	}
+
+	_, err = out.Write(body.Bytes())
+	return err
+}
+
+func putUvarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	buf.Write(b[:n])
+}
+
+func putVarint(buf *bytes.Buffer, v int64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], v)
+	buf.Write(b[:n])
+}
+
+// runTranscode reads the Loki chunk body following filename's ChunkHeader,
+// re-encodes it with targetName, writes the result to outPath, and prints a
+// size comparison per block plus a total.
+func runTranscode(filename, targetName, outPath string) error {
+	target, ok := lookupEncodingByName(targetName)
+	if !ok {
+		return fmt.Errorf("unknown target encoding %q", targetName)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h, err := DecodeHeader(f)
+	if err != nil {
+		return err
+	}
+
+	data := make([]byte, h.DataLength)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return fmt.Errorf("failed to read rawData for Loki chunk into memory: %w", err)
+	}
+
+	before, err := parseLokiChunkBytes(data, nil)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if err := TranscodeChunk(bytes.NewReader(data), out, target); err != nil {
+		return err
+	}
+
+	transcoded, err := ioutil.ReadFile(outPath)
+	if err != nil {
+		return err
+	}
+	after, err := parseLokiChunkBytes(transcoded, nil)
+	if err != nil {
+		return fmt.Errorf("failed to re-parse transcoded chunk: %w", err)
+	}
+
+	fmt.Printf("Transcoding %s: %s -> %s\n", filename, before.encoding, after.encoding)
+	var totalBefore, totalAfter uint64
+	for ix := range before.blocks {
+		b, a := before.blocks[ix], after.blocks[ix]
+		fmt.Printf("Block %4d: %8d -> %8d bytes (%.2fx)\n", ix, b.dataLength, a.dataLength, float64(b.dataLength)/float64(a.dataLength))
+		totalBefore += b.dataLength
+		totalAfter += a.dataLength
+	}
+	fmt.Printf("Total: %d -> %d bytes (%.2fx)\n", totalBefore, totalAfter, float64(totalBefore)/float64(totalAfter))
+
+	return nil
+}
+
+func lookupEncodingByName(name string) (Encoding, bool) {
+	for _, e := range Encodings {
+		if e.name == name {
+			return e, true
+		}
+	}
+	return Encoding{}, false
+}
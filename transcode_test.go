@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// buildTestChunk encodes entries as a single-block chunk using enc, in the
+// same layout TranscodeChunk itself writes (magic, format, code byte, one
+// compressed block plus its checksum, then the block-metadata footer). It's
+// a standalone builder rather than a reuse of TranscodeChunk so the
+// round-trip test below isn't just checking the function against itself.
+func buildTestChunk(t *testing.T, entries []LokiEntry, format byte, enc Encoding) []byte {
+	t.Helper()
+
+	var body bytes.Buffer
+	body.Write([]byte{0x01, 0x2E, 0xE5, 0x6A}) // magic number
+	body.WriteByte(format)
+	body.WriteByte(byte(enc.code))
+
+	offset := uint64(body.Len())
+
+	var compressed bytes.Buffer
+	w, err := enc.writerFn(&compressed)
+	if err != nil {
+		t.Fatalf("writerFn: %v", err)
+	}
+	raw := entriesToBytes(entries)
+	if format >= chunkFormatV4 {
+		raw = entriesToBytesWithMetadata(entries)
+	}
+	if _, err := w.Write(raw); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	body.Write(compressed.Bytes())
+	checksum := crc32.Checksum(compressed.Bytes(), castagnoliTable)
+	if err := binary.Write(&body, binary.BigEndian, checksum); err != nil {
+		t.Fatalf("write checksum: %v", err)
+	}
+
+	metasOffset := uint64(body.Len())
+
+	var metadata bytes.Buffer
+	putUvarint(&metadata, 1) // one block
+	putUvarint(&metadata, uint64(len(entries)))
+	putVarint(&metadata, entries[0].timestamp)
+	putVarint(&metadata, entries[len(entries)-1].timestamp)
+	putUvarint(&metadata, offset)
+	putUvarint(&metadata, uint64(compressed.Len()))
+
+	body.Write(metadata.Bytes())
+	metaChecksum := crc32.Checksum(metadata.Bytes(), castagnoliTable)
+	if err := binary.Write(&body, binary.BigEndian, metaChecksum); err != nil {
+		t.Fatalf("write meta checksum: %v", err)
+	}
+	if err := binary.Write(&body, binary.BigEndian, metasOffset); err != nil {
+		t.Fatalf("write metas offset: %v", err)
+	}
+
+	return body.Bytes()
+}
+
+func TestTranscodeChunkRoundTrip(t *testing.T) {
+	entries := []LokiEntry{
+		{timestamp: 1000, line: "hello"},
+		{timestamp: 2000, line: "world"},
+		{timestamp: 3000, line: "!"},
+	}
+
+	in := buildTestChunk(t, entries, chunkFormatV2, encNone)
+
+	var out bytes.Buffer
+	if err := TranscodeChunk(bytes.NewReader(in), &out, encGZIP); err != nil {
+		t.Fatalf("TranscodeChunk: %v", err)
+	}
+
+	transcoded, err := parseLokiChunkBytes(out.Bytes(), nil)
+	if err != nil {
+		t.Fatalf("failed to re-parse transcoded chunk: %v", err)
+	}
+	if transcoded.encoding.code != encGZIP.code {
+		t.Errorf("transcoded encoding = %v, want %v", transcoded.encoding, encGZIP)
+	}
+	if len(transcoded.blocks) != 1 {
+		t.Fatalf("len(blocks) = %d, want 1", len(transcoded.blocks))
+	}
+	if err := transcoded.decodeAllBlocks(); err != nil {
+		t.Fatalf("decodeAllBlocks: %v", err)
+	}
+
+	got := transcoded.blocks[0].entries
+	if len(got) != len(entries) {
+		t.Fatalf("len(entries) = %d, want %d", len(got), len(entries))
+	}
+	for i, want := range entries {
+		if got[i].timestamp != want.timestamp || got[i].line != want.line {
+			t.Errorf("entries[%d] = %+v, want %+v", i, got[i], want)
+		}
+	}
+}
+
+func TestTranscodeChunkRefusesStructuredMetadata(t *testing.T) {
+	entries := []LokiEntry{
+		{timestamp: 1000, line: "hello", StructuredMetadata: []labels.Label{{Name: "pod", Value: "foo"}}},
+	}
+	in := buildTestChunk(t, entries, chunkFormatV4, encNone)
+
+	var out bytes.Buffer
+	err := TranscodeChunk(bytes.NewReader(in), &out, encGZIP)
+	if err == nil {
+		t.Fatal("TranscodeChunk should refuse input with structured metadata, got nil error")
+	}
+}
+
+func TestTranscodeChunkNilWriterFn(t *testing.T) {
+	entries := []LokiEntry{{timestamp: 1000, line: "hello"}}
+	in := buildTestChunk(t, entries, chunkFormatV2, encNone)
+
+	var out bytes.Buffer
+	err := TranscodeChunk(bytes.NewReader(in), &out, Encoding{code: 99, name: "readonly"})
+	if err == nil {
+		t.Fatal("TranscodeChunk with a nil writerFn should return an error, not panic")
+	}
+}
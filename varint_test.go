@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestVarintReaderReadVarintUvarint(t *testing.T) {
+	var buf bytes.Buffer
+	var scratch [binary.MaxVarintLen64]byte
+
+	n := binary.PutVarint(scratch[:], -12345)
+	buf.Write(scratch[:n])
+	n = binary.PutUvarint(scratch[:], 67890)
+	buf.Write(scratch[:n])
+
+	// Force the reader to refill mid-stream instead of handing back
+	// everything in one Read, since that's what a real decompressor does.
+	vr := newVarintReader(iotest.OneByteReader(bytes.NewReader(buf.Bytes())))
+
+	got, _, err := vr.readVarint()
+	if err != nil {
+		t.Fatalf("readVarint: %v", err)
+	}
+	if got != -12345 {
+		t.Errorf("readVarint = %d, want -12345", got)
+	}
+
+	gotU, _, err := vr.readUvarint()
+	if err != nil {
+		t.Fatalf("readUvarint: %v", err)
+	}
+	if gotU != 67890 {
+		t.Errorf("readUvarint = %d, want 67890", gotU)
+	}
+
+	if _, _, err := vr.readVarint(); err != io.EOF {
+		t.Errorf("readVarint at end = %v, want io.EOF", err)
+	}
+}
+
+func TestVarintReaderReadString(t *testing.T) {
+	vr := newVarintReader(iotest.OneByteReader(bytes.NewReader([]byte("hello world"))))
+
+	s, err := vr.readString(5)
+	if err != nil {
+		t.Fatalf("readString: %v", err)
+	}
+	if s != "hello" {
+		t.Errorf("readString = %q, want %q", s, "hello")
+	}
+
+	s, err = vr.readString(6)
+	if err != nil {
+		t.Fatalf("readString: %v", err)
+	}
+	if s != " world" {
+		t.Errorf("readString = %q, want %q", s, " world")
+	}
+}
+
+func TestVarintReaderReadStringPastEOF(t *testing.T) {
+	vr := newVarintReader(bytes.NewReader([]byte("ab")))
+
+	if _, err := vr.readString(5); err == nil {
+		t.Error("readString past end of data should return an error, got nil")
+	}
+}
+
+// TestVarintReaderRefillAcrossBoundary exercises the sliding-window refill:
+// the buffer is grown in 4096-byte chunks, so a stream bigger than that
+// forces fill to top up mid-decode rather than in one shot.
+func TestVarintReaderRefillAcrossBoundary(t *testing.T) {
+	var buf bytes.Buffer
+	var scratch [binary.MaxVarintLen64]byte
+
+	const count = 4000 // 64 one-byte + 3936 two-byte varints = 7936 bytes
+	for i := 0; i < count; i++ {
+		n := binary.PutVarint(scratch[:], int64(i))
+		buf.Write(scratch[:n])
+	}
+
+	vr := newVarintReader(&buf)
+	for i := 0; i < count; i++ {
+		got, _, err := vr.readVarint()
+		if err != nil {
+			t.Fatalf("readVarint(%d): %v", i, err)
+		}
+		if got != int64(i) {
+			t.Fatalf("readVarint(%d) = %d, want %d", i, got, i)
+		}
+	}
+	if _, _, err := vr.readVarint(); err != io.EOF {
+		t.Errorf("readVarint at end = %v, want io.EOF", err)
+	}
+}